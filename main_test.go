@@ -0,0 +1,372 @@
+package main
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "net"
+    "strings"
+    "testing"
+    "time"
+)
+
+// The samples of RFC 3492, section 7.1
+func TestPunycode(t *testing.T) {
+    tests := []struct {
+        name    string
+        unicode string
+        encoded string
+    }{
+        {"arabic", "ليهمابتكلموشعربي؟", "egbpdaj6bu4bxfgehfvwxn"},
+        {"chinese simplified", "他们为什么不说中文", "ihqwcrb4cv8a8dqg056pqjye"},
+        {"chinese traditional", "他們爲什麽不說中文", "ihqwctvzc91f659drss3x8bo0yb"},
+        {"japanese with ascii", "3年B組金八先生", "3B-ww4c5e180e575a65lsy2b"},
+        {"leading hyphen", "安室奈美恵-with-SUPER-MONKEYS", "-with-SUPER-MONKEYS-pc58ag80a8qai00g7n9n"},
+        {"trailing hyphen in basic part", "Hello-Another-Way-それぞれの場所", "Hello-Another-Way--fc4qua05auwb3674vfr0b"},
+        {"mixed scripts", "MajiでKoiする5秒前", "MajiKoi5-783gue6qz075azm5e"},
+        {"kana only", "そのスピードで", "d9juau41awczczp"},
+        {"basic only", "-> $1.00 <-", "-> $1.00 <--"},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            encoded, err := punycodeEncode(tt.unicode)
+            if err != nil || encoded != tt.encoded {
+                t.Errorf("punycodeEncode(%q) = %q, %v; want %q", tt.unicode, encoded, err, tt.encoded)
+            }
+            decoded, err := punycodeDecode(tt.encoded)
+            if err != nil || decoded != tt.unicode {
+                t.Errorf("punycodeDecode(%q) = %q, %v; want %q", tt.encoded, decoded, err, tt.unicode)
+            }
+        })
+    }
+}
+
+func TestDomainToASCII(t *testing.T) {
+    tests := []struct {
+        domain string
+        want   string
+    }{
+        {"example.com", "example.com"},
+        {"München.de", "xn--mnchen-3ya.de"},
+        {"магазин.рф.", "xn--80aairftm.xn--p1ai"},
+    }
+
+    for _, tt := range tests {
+        got, err := domainToASCII(tt.domain)
+        if err != nil || got != tt.want {
+            t.Errorf("domainToASCII(%q) = %q, %v; want %q", tt.domain, got, err, tt.want)
+        }
+        if unicode := domainToUnicode(got); domainToUnicode(tt.want) != unicode {
+            t.Errorf("domainToUnicode(%q) = %q", got, unicode)
+        }
+    }
+}
+
+const testSuffixList = `// ===BEGIN ICANN DOMAINS===
+com
+jp
+*.kawasaki.jp
+!city.kawasaki.jp
+*.ck
+!www.ck
+// ===END ICANN DOMAINS===
+// ===BEGIN PRIVATE DOMAINS===
+blogspot.com
+// ===END PRIVATE DOMAINS===
+`
+
+func TestPublicSuffix(t *testing.T) {
+    list := parsePublicSuffixList(testSuffixList)
+    tests := []struct {
+        domain string
+        want   string
+        icann  string
+    }{
+        {"example.com", "com", "com"},
+        {"www.example.com", "com", "com"},
+        {"example.unlisted", "unlisted", "unlisted"},
+        // Wildcards take the label in front of the rule too
+        {"b.ck", "b.ck", "b.ck"},
+        {"a.b.ck", "b.ck", "b.ck"},
+        {"foo.bar.kawasaki.jp", "bar.kawasaki.jp", "bar.kawasaki.jp"},
+        // Exceptions cancel the wildcard
+        {"www.ck", "ck", "ck"},
+        {"city.kawasaki.jp", "kawasaki.jp", "kawasaki.jp"},
+        {"foo.city.kawasaki.jp", "kawasaki.jp", "kawasaki.jp"},
+        // Private rules only count in the full list
+        {"foo.blogspot.com", "blogspot.com", "com"},
+    }
+
+    for _, tt := range tests {
+        if got := list.PublicSuffix(tt.domain); got != tt.want {
+            t.Errorf("PublicSuffix(%q) = %q, want %q", tt.domain, got, tt.want)
+        }
+        if got := list.icann.PublicSuffix(tt.domain); got != tt.icann {
+            t.Errorf("icann.PublicSuffix(%q) = %q, want %q", tt.domain, got, tt.icann)
+        }
+    }
+}
+
+func TestSplitDomain(t *testing.T) {
+    tests := []struct {
+        domain      string
+        registrable string
+        subdomain   string
+    }{
+        {"example.com.br", "example.com.br", ""},
+        {"www.shop.example.com.br", "example.com.br", "www.shop"},
+        {"com.br", "", ""},
+    }
+
+    for _, tt := range tests {
+        registrable, subdomain := splitDomain(tt.domain)
+        if registrable != tt.registrable || subdomain != tt.subdomain {
+            t.Errorf("splitDomain(%q) = %q, %q; want %q, %q", tt.domain, registrable, subdomain, tt.registrable, tt.subdomain)
+        }
+    }
+}
+
+func TestRedisRoundTrip(t *testing.T) {
+    tests := []struct {
+        name    string
+        args    []string
+        request string
+        reply   string
+        want    interface{}
+        wantErr string
+    }{
+        {"simple string", []string{"PING"}, "*1\r\n$4\r\nPING\r\n", "+PONG\r\n", "PONG", ""},
+        {"bulk string", []string{"GET", "k"}, "*2\r\n$3\r\nGET\r\n$1\r\nk\r\n", "$5\r\nhello\r\n", "hello", ""},
+        {"bulk string with crlf", []string{"GET", "k"}, "*2\r\n$3\r\nGET\r\n$1\r\nk\r\n", "$7\r\na\r\nb\r\nc\r\n", "a\r\nb\r\nc", ""},
+        {"empty bulk string", []string{"GET", "k"}, "*2\r\n$3\r\nGET\r\n$1\r\nk\r\n", "$0\r\n\r\n", "", ""},
+        {"missing value", []string{"GET", "k"}, "*2\r\n$3\r\nGET\r\n$1\r\nk\r\n", "$-1\r\n", nil, ""},
+        {"integer", []string{"DEL", "k"}, "*2\r\n$3\r\nDEL\r\n$1\r\nk\r\n", ":1\r\n", int64(1), ""},
+        {"error", []string{"AUTH", "x"}, "*2\r\n$4\r\nAUTH\r\n$1\r\nx\r\n", "-ERR invalid password\r\n", nil, "redis: ERR invalid password"},
+        {"utf-8 argument", []string{"GET", "ção"}, "*2\r\n$3\r\nGET\r\n$5\r\nção\r\n", "$-1\r\n", nil, ""},
+        {"unsupported reply", []string{"KEYS", "*"}, "*2\r\n$4\r\nKEYS\r\n$1\r\n*\r\n", "*0\r\n", nil, `redis: unsupported reply "*0"`},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            client, server := net.Pipe()
+            defer client.Close()
+            requests := make(chan string, 1)
+            go func() {
+                defer server.Close()
+                buf := make([]byte, len(tt.request))
+                n, _ := io.ReadFull(server, buf)
+                requests <- string(buf[:n])
+                server.Write([]byte(tt.reply))
+            }()
+
+            c := &redisCache{conn: client, reader: bufio.NewReader(client)}
+            got, err := c.roundTrip(tt.args...)
+            if request := <-requests; request != tt.request {
+                t.Errorf("request = %q, want %q", request, tt.request)
+            }
+            if tt.wantErr != "" {
+                if err == nil || err.Error() != tt.wantErr {
+                    t.Fatalf("err = %v, want %q", err, tt.wantErr)
+                }
+                return
+            }
+            if err != nil || got != tt.want {
+                t.Errorf("reply = %#v, %v; want %#v", got, err, tt.want)
+            }
+        })
+    }
+}
+
+// natsServer accepts one connection, greets it and answers PINGs with
+// reply, sending every other line it receives on lines. After a message
+// payload it sends a PING of its own.
+func natsServer(t *testing.T, reply string) (addr string, lines chan string) {
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { listener.Close() })
+
+    lines = make(chan string, 16)
+    go func() {
+        conn, err := listener.Accept()
+        if err != nil {
+            return
+        }
+        defer conn.Close()
+        io.WriteString(conn, "INFO {\"server_id\":\"test\"}\r\n")
+        reader := bufio.NewReader(conn)
+        for {
+            line, err := reader.ReadString('\n')
+            if err != nil {
+                close(lines)
+                return
+            }
+            line = strings.TrimRight(line, "\r\n")
+            if line == "PING" {
+                io.WriteString(conn, reply)
+                continue
+            }
+            lines <- line
+            if strings.HasPrefix(line, "{") {
+                io.WriteString(conn, "PING\r\n")
+            }
+        }
+    }()
+    return listener.Addr().String(), lines
+}
+
+func TestNATSPublisher(t *testing.T) {
+    tests := []struct {
+        name    string
+        spec    string // %s is the server address
+        reply   string
+        connect []string // expected parts of the CONNECT options
+        pub     string
+        wantErr string
+    }{
+        {"plain", "nats://%s/wpcheck/results", "PONG\r\n", []string{`"verbose":false`}, "PUB wpcheck.results ", ""},
+        {"user and password", "nats://alice:secret@%s/results", "PONG\r\n", []string{`"user":"alice"`, `"pass":"secret"`}, "PUB results ", ""},
+        {"token", "nats://s3cr3t@%s/results", "PONG\r\n", []string{`"auth_token":"s3cr3t"`}, "PUB results ", ""},
+        // Extra PONGs must not stall the reader that answers server PINGs
+        {"duplicate pongs", "nats://%s/results", "PONG\r\nPONG\r\nPONG\r\n", nil, "PUB results ", ""},
+        {"rejected", "nats://%s/results", "-ERR 'Authorization Violation'\r\n", nil, "", "-ERR 'Authorization Violation'"},
+        {"no subject", "nats://%s/", "PONG\r\n", nil, "", "nats destination must be nats://host/subject"},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            addr, lines := natsServer(t, tt.reply)
+            p, err := newNATSPublisher(fmt.Sprintf(tt.spec, addr), sinkOptions{})
+            if tt.wantErr != "" {
+                if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+                    t.Fatalf("err = %v, want %q", err, tt.wantErr)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            connect := <-lines
+            for _, part := range tt.connect {
+                if !strings.HasPrefix(connect, "CONNECT ") || !strings.Contains(connect, part) {
+                    t.Errorf("CONNECT line %q lacks %s", connect, part)
+                }
+            }
+
+            if err := p.Write(Result{Domain: "example.com"}); err != nil {
+                t.Fatal(err)
+            }
+            if pub := <-lines; !strings.HasPrefix(pub, tt.pub) {
+                t.Errorf("publish line = %q, want prefix %q", pub, tt.pub)
+            }
+            if payload := <-lines; !strings.Contains(payload, `"domain":"example.com"`) {
+                t.Errorf("payload = %q", payload)
+            }
+            select {
+            case pong := <-lines:
+                if pong != "PONG" {
+                    t.Errorf("answer to server PING = %q", pong)
+                }
+            case <-time.After(5 * time.Second):
+                t.Fatal("server PING not answered")
+            }
+            if err := p.Close(); err != nil {
+                t.Fatal(err)
+            }
+        })
+    }
+}
+
+func TestWherePrecedence(t *testing.T) {
+    record, err := resultRecord(Result{Domain: "example.com", IsWordPress: true, WordPressVersion: "6.10", StatusCode: 200})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        expr string
+        want bool
+    }{
+        // && binds tighter than ||, on either side
+        {"true || false && false", true},
+        {"false && true || true", true},
+        {"(true || false) && false", false},
+        {"false && (true || true)", false},
+        // Comparisons bind tighter than && and ||
+        {"status_code == 200 && is_wordpress", true},
+        {"status_code == 404 || wordpress_version > \"6.9\"", true},
+        {"is_wordpress == false || status_code < 300 && wordpress_version == \"\"", false},
+        // ! applies to the whole comparison that follows it
+        {"!status_code == 404", true},
+        {"!is_wordpress && status_code == 200", false},
+        {"!(is_wordpress && status_code == 404)", true},
+        {"!!is_wordpress", true},
+        // Left to right within one level
+        {"false || false || true", true},
+        {"true && true && false", false},
+        {"domain contains \"EXAMPLE\" && !(wordpress_version < \"6.2\")", true},
+    }
+
+    for _, tt := range tests {
+        expr, err := parseWhere(tt.expr)
+        if err != nil {
+            t.Errorf("parseWhere(%q): %v", tt.expr, err)
+            continue
+        }
+        if got := truthy(expr.eval(record)); got != tt.want {
+            t.Errorf("%s = %v, want %v", tt.expr, got, tt.want)
+        }
+    }
+}
+
+func TestWhereErrors(t *testing.T) {
+    tests := []string{
+        "(true || false",
+        "true &&",
+        "true false",
+        "status_code = 200",
+        "no_such_field == 1",
+    }
+
+    for _, expr := range tests {
+        if _, err := parseWhere(expr); err == nil {
+            t.Errorf("parseWhere(%q) succeeded", expr)
+        }
+    }
+}
+
+func TestFieldAliases(t *testing.T) {
+    record, err := resultRecord(Result{WordPressVersion: "6.4.2", Plugins: []string{"woocommerce"}})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        expr string
+        want bool
+    }{
+        {"wp_version == \"6.4.2\"", true},
+        {"wp_version < \"6.4\"", false},
+        {"wp_plugins contains \"woocommerce\"", true},
+    }
+
+    for _, tt := range tests {
+        expr, err := parseWhere(tt.expr)
+        if err != nil {
+            t.Errorf("parseWhere(%q): %v", tt.expr, err)
+            continue
+        }
+        if got := truthy(expr.eval(record)); got != tt.want {
+            t.Errorf("%s = %v, want %v", tt.expr, got, tt.want)
+        }
+    }
+
+    cfg := &Config{Fields: "domain,wp_version"}
+    validateConfig(cfg)
+    if len(cfg.fields) != 2 || cfg.fields[1] != "wordpress_version" {
+        t.Errorf("--fields domain,wp_version selected %v", cfg.fields)
+    }
+}
@@ -0,0 +1,2 @@
+/*! Emoji script fixture: the copy shipped by 6.4.0 and 6.4.1 */
+!function(i,n){var o,s,e;function c(e){try{var t={supportTests:e};sessionStorage.setItem(o,JSON.stringify(t))}catch(e){}}}(window,document);
@@ -0,0 +1,2 @@
+/*! Emoji script fixture: the copy shipped by 6.3.0 to 6.3.2 and 6.4.2 */
+!function(i,n){var o,s,e;function c(e){try{var t={supportTests:e,timestamp:(new Date).valueOf()};sessionStorage.setItem(o,JSON.stringify(t))}catch(e){}}}(window,document);